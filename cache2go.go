@@ -0,0 +1,40 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import "sync"
+
+// cacheMutex guards cache.
+// 【保护cache】
+var cacheMutex sync.RWMutex
+
+// cache holds every table ever requested through Cache, keyed by name.
+// 【通过Cache请求过的所有缓存表， 以名称为key】
+var cache = make(map[string]*CacheTable)
+
+// Cache returns the existing cache table with the given name, creating
+// and registering a new, empty one on first use. Every subsequent call
+// with the same name returns that same *CacheTable.
+// 【返回已存在的同名缓存表， 不存在则创建并注册， 同名多次调用返回同一个CacheTable】
+func Cache(name string) *CacheTable {
+	cacheMutex.RLock()
+	table, ok := cache[name]
+	cacheMutex.RUnlock()
+	if ok {
+		return table
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	table, ok = cache[name]
+	if !ok {
+		table = newCacheTable(name)
+		cache[name] = table
+	}
+	return table
+}
@@ -38,6 +38,10 @@ type CacheItem struct {
 	// How often the item was accessed.
 	// 【访问的次数，KeepAlive函数修改】
 	accessCount int64
+	// The item's cost, used by capacity-bounded tables to weigh eviction
+	// decisions. Defaults to 1, so a plain item count is just cost-by-count.
+	// 【条目的开销，用于容量受限的缓存表做淘汰决策，默认1】
+	cost int64
 
 	// Callback method triggered right before removing the item from the cache
 	// 【被移除时候的回调函数】
@@ -58,6 +62,7 @@ func NewCacheItem(key interface{}, lifeSpan time.Duration, data interface{}) *Ca
 		createdOn:     t,
 		accessedOn:    t,
 		accessCount:   0,
+		cost:          1,
 		aboutToExpire: nil,
 		data:          data,
 	}
@@ -102,6 +107,28 @@ func (item *CacheItem) AccessCount() int64 {
 	return item.accessCount
 }
 
+// Cost returns the item's cost as used by capacity-bounded eviction.
+// 【返回cost， 用于按总开销做容量限制】
+func (item *CacheItem) Cost() int64 {
+	item.RLock()
+	defer item.RUnlock()
+	return item.cost
+}
+
+// SetCost sets the item's cost as used by capacity-bounded eviction.
+// Items default to a cost of 1, i.e. a plain item count. SetCost only
+// rewrites the item itself; it does not adjust a table's totalCost or
+// re-trigger eviction, so it must not be used to change the cost of an
+// item already inserted into a cost-bounded table. Use
+// CacheTable.AddCost to set an item's cost at insertion time instead.
+// 【设置cost， 默认1； 这只修改条目本身， 不会更新所属table的totalCost也不会重新触发淘汰，
+// 因此不能用来修改已插入受开销限制的table中条目的cost； 插入时指定cost请用CacheTable.AddCost】
+func (item *CacheItem) SetCost(cost int64) {
+	item.Lock()
+	defer item.Unlock()
+	item.cost = cost
+}
+
 // Key returns the key of this cached item.
 // 【返回key】
 func (item *CacheItem) Key() interface{} {
@@ -8,9 +8,12 @@
 package cache2go
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,12 +44,152 @@ type CacheTable struct {
 	// Callback method triggered when trying to load a non-existing key.
 	// 【加载一个不存在的key时触发的回调函数】
 	loadData func(key interface{}, args ...interface{}) *CacheItem
+	// Like loadData, but also receives the context.Context passed to
+	// ValueContext, e.g. to attach a tracing span. Takes precedence over
+	// loadData when set.
+	// 【加载一个不存在的key时触发的回调函数， 带上ValueContext传入的context， 设置时优先于loadData】
+	loadDataCtx func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem
 	// Callback method triggered when adding a new item to the cache.
 	// 【添加缓存条目时触发的回调函数】
 	addedItem []func(item *CacheItem)
 	// Callback method triggered before deleting an item from the cache.
 	// 【删除缓存条目时触发的回调函数】
 	aboutToDeleteItem []func(item *CacheItem)
+
+	// Maximum number of items the table may hold, or 0 for unbounded.
+	// 【最大条目数， 0表示不限制】
+	capacity int
+	// Maximum total cost (sum of CacheItem.Cost) the table may hold, or 0
+	// for unbounded.
+	// 【最大总开销， 0表示不限制】
+	maxCost int64
+	// Running total of all items' cost, kept in sync under table.Lock.
+	// 【当前总开销】
+	totalCost int64
+	// The policy consulted to pick a victim and decide admission once the
+	// table is at capacity. nil means no eviction is performed, i.e. the
+	// table behaves as before.
+	// 【淘汰策略， 为nil表示不做容量淘汰】
+	evictionPolicy EvictionPolicy
+
+	// Whether concurrent Value misses for the same key should be
+	// coalesced into a single loadData call.
+	// 【是否合并并发的loadData调用】
+	loaderSingleflight bool
+	// Guards loaderCalls.
+	// 【保护loaderCalls】
+	loaderMutex sync.Mutex
+	// In-flight loadData calls, keyed by cache key.
+	// 【正在进行中的loadData调用】
+	loaderCalls map[interface{}]*loadCall
+
+	// Running persistence state, set by EnablePersistence. nil means the
+	// table isn't persisted.
+	// 【持久化状态， 为nil表示未开启持久化】
+	persist *tablePersistence
+
+	// Operation counters, updated atomically so Stats() never blocks on
+	// table.Lock.
+	// 【操作计数器， 原子更新】
+	stats tableStats
+
+	// Guards subscribers.
+	// 【保护subscribers】
+	subMu sync.Mutex
+	// Channels handed out by Subscribe.
+	// 【Subscribe返回的事件订阅channel】
+	subscribers []chan Event
+	// Count of events dropped because a subscriber's channel was full.
+	// 【因订阅者channel已满而丢弃的事件数】
+	eventsDropped int64
+}
+
+// loadCall represents an in-flight or completed loadData call shared by
+// every concurrent Value caller asking for the same key.
+// 【一次loadData调用， 同一个key的并发调用共享同一个loadCall】
+type loadCall struct {
+	wg   sync.WaitGroup
+	item *CacheItem
+	err  error
+}
+
+// SetLoaderSingleflight toggles whether concurrent Value misses for the
+// same key are coalesced into a single loadData call. It defaults to
+// false so existing callers keep today's one-loadData-per-miss behavior
+// unless they opt in.
+// 【设置是否合并并发的loadData调用， 默认false以兼容旧行为】
+func (table *CacheTable) SetLoaderSingleflight(enabled bool) {
+	table.Lock()
+	defer table.Unlock()
+	table.loaderSingleflight = enabled
+}
+
+// SetCapacity bounds the table to at most n items. Pass 0 to remove the
+// bound. A policy must be set via SetEvictionPolicy for the bound to
+// have any effect.
+// 【设置最大条目数， 需要搭配SetEvictionPolicy使用】
+func (table *CacheTable) SetCapacity(n int) {
+	table.Lock()
+	defer table.Unlock()
+	table.capacity = n
+}
+
+// SetMaxCost bounds the table to at most cost total CacheItem.Cost. Pass
+// 0 to remove the bound. A policy must be set via SetEvictionPolicy for
+// the bound to have any effect.
+// 【设置最大总开销， 需要搭配SetEvictionPolicy使用】
+func (table *CacheTable) SetMaxCost(cost int64) {
+	table.Lock()
+	defer table.Unlock()
+	table.maxCost = cost
+}
+
+// SetEvictionPolicy configures the policy used to pick a victim and
+// decide admission once the table is at its capacity or cost bound.
+// 【设置淘汰策略】
+func (table *CacheTable) SetEvictionPolicy(policy EvictionPolicy) {
+	table.Lock()
+	defer table.Unlock()
+	table.evictionPolicy = policy
+}
+
+// EvictionStats returns the admission/rejection/eviction counters of the
+// configured eviction policy. The zero value is returned if no policy is
+// set.
+// 【返回淘汰策略的统计信息】
+func (table *CacheTable) EvictionStats() EvictionStats {
+	table.RLock()
+	defer table.RUnlock()
+	if table.evictionPolicy == nil {
+		return EvictionStats{}
+	}
+	return table.evictionPolicy.Stats()
+}
+
+// atCapacity reports whether adding one more item of the given cost
+// would exceed the configured item-count or total-cost bound. Callers
+// must hold table.Lock.
+func (table *CacheTable) atCapacity(cost int64) bool {
+	if table.capacity > 0 && len(table.items) >= table.capacity {
+		return true
+	}
+	if table.maxCost > 0 && table.totalCost+cost > table.maxCost {
+		return true
+	}
+	return false
+}
+
+// newCacheTable creates a ready to use, empty CacheTable with the given
+// name. Exported construction normally goes through cache2go.Cache,
+// which also registers the table for reuse; newCacheTable is used by
+// internal callers, such as ShardedCacheTable, that manage their own set
+// of tables directly.
+// 【创建一个空的CacheTable， 供内部使用， 如ShardedCacheTable管理自己的多个CacheTable】
+func newCacheTable(name string) *CacheTable {
+	return &CacheTable{
+		name:  name,
+		items: make(map[interface{}]*CacheItem),
+	}
 }
 
 // Count returns how many items are currently stored in the cache.
@@ -78,6 +221,17 @@ func (table *CacheTable) SetDataLoader(f func(interface{}, ...interface{}) *Cach
 	table.loadData = f
 }
 
+// SetContextDataLoader is like SetDataLoader, but f also receives the
+// context.Context passed to ValueContext, e.g. to attach a tracing span
+// around the load. It takes precedence over a plain SetDataLoader
+// callback when both are set.
+// 【设置带context的loadData回调函数， 优先于SetDataLoader设置的回调】
+func (table *CacheTable) SetContextDataLoader(f func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem) {
+	table.Lock()
+	defer table.Unlock()
+	table.loadDataCtx = f
+}
+
 // SetAddedItemCallback configures a callback, which will be called every time
 // a new item is added to the cache.
 // 【设置添加缓存条目时触发的回调函数】
@@ -177,7 +331,10 @@ func (table *CacheTable) expirationCheck() {
 		// 【已经过期了，删除】
 		if now.Sub(accessedOn) >= lifeSpan {
 			// Item has excessed its lifespan.
-			table.deleteInternal(key)
+			if r, err := table.deleteInternal(key); err == nil {
+				atomic.AddInt64(&table.stats.expirations, 1)
+				table.publish(Event{Type: EventExpired, Key: key, Item: r})
+			}
 		} else {
 			// Find the item chronologically closest to its end-of-lifespan.
 			// 【更新smallestDuration， 获取最近一个将要过期的时间间隔】
@@ -199,12 +356,84 @@ func (table *CacheTable) expirationCheck() {
 	table.Unlock()
 }
 
+// evictForSpace makes room for a new item of the given cost by asking
+// the eviction policy for a victim and, if the policy admits candidate
+// in its place, deleting it. Since a single eviction only frees one
+// victim's cost, this loops until the table is no longer at capacity,
+// the policy has nothing left to evict, or the policy rejects
+// candidate — a single eviction isn't enough to satisfy a total-cost
+// bound once items have varying cost. Returns false if the policy
+// rejected the candidate, in which case the caller must not insert it.
+// 【容量已满时向淘汰策略要victim； 由于开销不同的条目可能需要淘汰多个才能腾出足够空间，
+// 所以循环淘汰直到不再超限、策略无条目可淘汰、或策略拒绝新条目】
+// Careful: do not run this method unless the table-mutex is locked!
+func (table *CacheTable) evictForSpace(candidate interface{}, cost int64) bool {
+	for table.evictionPolicy != nil && table.atCapacity(cost) {
+		victim, ok := table.evictionPolicy.Victim()
+		if !ok {
+			return true
+		}
+		if !table.evictionPolicy.Admit(candidate, victim) {
+			// Record the attempt here, since a rejected candidate never
+			// reaches addInternal's Touch call below. An admitted
+			// candidate does reach it, so it must not also be recorded
+			// here too, or its frequency would be double-counted for
+			// this one insertion.
+			// 【被拒绝的候选不会走到下面addInternal里的Touch调用， 所以要在这里单独记录；
+			// 被接纳的候选会走到Touch， 这里就不能重复记录， 否则这次插入的频率会被计两次】
+			table.evictionPolicy.Seen(candidate)
+			return false
+		}
+		table.log("Evicting item with key", victim, "from table", table.name, "to admit", candidate)
+		if r, err := table.deleteInternal(victim); err == nil {
+			atomic.AddInt64(&table.stats.evictions, 1)
+			table.publish(Event{Type: EventEvicted, Key: victim, Item: r})
+		} else {
+			// The victim is no longer in the table (e.g. after Flush);
+			// drop it from the policy so the next Victim call moves on.
+			table.evictionPolicy.Remove(victim)
+		}
+	}
+	return true
+}
+
 // 【内部添加函数， 代码重用， 调用这个方法之前需要加锁】
 func (table *CacheTable) addInternal(item *CacheItem) {
 	// Careful: do not run this method unless the table-mutex is locked!
 	// It will unlock it for the caller before running the callbacks and checks
+	old, exists := table.items[item.key]
+	if !exists {
+		// 【容量已满则向淘汰策略要空间， 被拒绝则放弃本次添加】
+		if !table.evictForSpace(item.key, item.cost) {
+			table.Unlock()
+			return
+		}
+	} else {
+		table.totalCost -= old.cost
+	}
+
 	table.log("Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
 	table.items[item.key] = item
+	table.totalCost += item.cost
+	atomic.AddInt64(&table.stats.adds, 1)
+	atomic.StoreInt64(&table.stats.currentSize, int64(len(table.items)))
+	if exists {
+		atomic.AddInt64(&table.stats.bytesEstimated, item.cost-old.cost)
+	} else {
+		atomic.AddInt64(&table.stats.bytesEstimated, item.cost)
+	}
+	if table.evictionPolicy != nil {
+		table.evictionPolicy.Touch(item.key)
+	}
+	if table.persist != nil {
+		// The item was just created, so its remaining TTL is its full
+		// lifespan.
+		// 【条目刚创建， 剩余TTL即为完整的lifeSpan】
+		if err := table.persist.appendRecord(opAdd, item.key, item.lifeSpan, item.lifeSpan, item.data); err != nil {
+			table.log("Persistence WAL append failed for table", table.name, ":", err)
+		}
+	}
+	table.publish(Event{Type: EventAdded, Key: item.key, Item: item})
 
 	// Cache values so we don't keep blocking the mutex.
 	expDur := table.cleanupInterval
@@ -226,12 +455,14 @@ func (table *CacheTable) addInternal(item *CacheItem) {
 	}
 }
 
-// Add adds a key/value pair to the cache.
+// Add adds a key/value pair to the cache. The item is created with the
+// default cost of 1; use AddCost to insert at a caller-chosen cost under
+// SetMaxCost.
 // Parameter key is the item's cache-key.
 // Parameter lifeSpan determines after which time period without an access the item
 // will get removed from the cache.
 // Parameter data is the item's value.
-// 【添加缓存条目到缓存表中， addInternal会释放锁】
+// 【添加缓存条目到缓存表中， 使用默认cost 1； 需要自定义cost时用AddCost； addInternal会释放锁】
 func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
 	item := NewCacheItem(key, lifeSpan, data)
 
@@ -242,6 +473,24 @@ func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data inter
 	return item
 }
 
+// AddCost is like Add, but lets the caller set the item's cost at
+// insertion time, so it is reflected in table.totalCost (and therefore
+// SetMaxCost) from the moment the item becomes visible. CacheItem.SetCost
+// only rewrites the item in place afterwards; it cannot retroactively
+// correct totalCost or re-trigger eviction, so it is not a substitute for
+// this when the table is cost-bounded.
+// 【与Add类似， 但可以在插入时就指定cost， 从而立即计入totalCost（配合SetMaxCost生效）；
+// 事后调用CacheItem.SetCost无法追溯更新totalCost或重新触发淘汰， 容量受开销限制时不能替代这个方法】
+func (table *CacheTable) AddCost(key interface{}, lifeSpan time.Duration, data interface{}, cost int64) *CacheItem {
+	item := NewCacheItem(key, lifeSpan, data)
+	item.cost = cost
+
+	table.Lock()
+	table.addInternal(item)
+
+	return item
+}
+
 // 【内部删除函数， 代码重用， 调用这个方法之前需要加锁】
 func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
 	r, ok := table.items[key]
@@ -273,6 +522,20 @@ func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
 	table.Lock()
 	table.log("Deleting item with key", key, "created on", r.createdOn, "and hit", r.accessCount, "times from table", table.name)
 	delete(table.items, key)
+	table.totalCost -= r.cost
+	atomic.StoreInt64(&table.stats.currentSize, int64(len(table.items)))
+	atomic.AddInt64(&table.stats.bytesEstimated, -r.cost)
+	if table.evictionPolicy != nil {
+		table.evictionPolicy.Remove(key)
+	}
+	if table.persist != nil {
+		// Tombstone the key, whether this deletion is explicit, a
+		// passive expiration, or an eviction.
+		// 【无论是主动删除、过期还是淘汰都写入墓碑记录】
+		if err := table.persist.appendRecord(opDelete, key, 0, 0, nil); err != nil {
+			table.log("Persistence WAL append failed for table", table.name, ":", err)
+		}
+	}
 
 	return r, nil
 }
@@ -283,7 +546,13 @@ func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
 	table.Lock()
 	defer table.Unlock()
 
-	return table.deleteInternal(key)
+	r, err := table.deleteInternal(key)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&table.stats.deletes, 1)
+	table.publish(Event{Type: EventDeleted, Key: key, Item: r})
+	return r, nil
 }
 
 // Exists returns whether an item exists in the cache. Unlike the Value method
@@ -319,30 +588,106 @@ func (table *CacheTable) NotFoundAdd(key interface{}, lifeSpan time.Duration, da
 // pass additional arguments to your DataLoader callback function.
 // 【获取value, 会通过KeepAlive更新访问时间和访问次数】
 func (table *CacheTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+	return table.ValueContext(context.Background(), key, args...)
+}
+
+// ValueContext is like Value, but propagates ctx to a context-aware data
+// loader set via SetContextDataLoader, e.g. so callers can attach an
+// OpenTelemetry span to the loader call.
+// 【与Value相同， 但会把ctx传给SetContextDataLoader设置的加载回调】
+func (table *CacheTable) ValueContext(ctx context.Context, key interface{}, args ...interface{}) (*CacheItem, error) {
 	table.RLock()
 	r, ok := table.items[key]
 	loadData := table.loadData
+	loadDataCtx := table.loadDataCtx
+	singleflight := table.loaderSingleflight
 	table.RUnlock()
 
 	if ok {
 		// Update access counter and timestamp.
+		atomic.AddInt64(&table.stats.hits, 1)
 		r.KeepAlive()
+		if table.evictionPolicy != nil {
+			table.Lock()
+			table.evictionPolicy.Touch(key)
+			table.Unlock()
+		}
+		table.publish(Event{Type: EventAccessed, Key: key, Item: r})
 		return r, nil
 	}
+	atomic.AddInt64(&table.stats.misses, 1)
 
 	// Item doesn't exist in cache. Try and fetch it with a data-loader.
 	// 【如果不存在，通过loadData获取】
-	if loadData != nil {
-		item := loadData(key, args...)
-		if item != nil {
-			table.Add(key, item.lifeSpan, item.data)
-			return item, nil
+	if loadDataCtx == nil && loadData == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	loader := loadData
+	if loadDataCtx != nil {
+		loader = func(key interface{}, args ...interface{}) *CacheItem {
+			return loadDataCtx(ctx, key, args...)
 		}
+	}
 
+	if singleflight {
+		return table.loadSingleflight(key, loader, args...)
+	}
+	return table.load(key, loader, args...)
+}
+
+// load runs loadData for key and, if it returned an item, adds it to the
+// table. A panicking loadData is recovered and reported as
+// ErrLoaderPanicked instead of crashing the caller.
+// 【运行loadData并把结果加入缓存表， loadData发生panic时转换为ErrLoaderPanicked】
+func (table *CacheTable) load(key interface{}, loadData func(interface{}, ...interface{}) *CacheItem, args ...interface{}) (item *CacheItem, err error) {
+	atomic.AddInt64(&table.stats.loaderCalls, 1)
+	defer func() {
+		if rec := recover(); rec != nil {
+			item, err = nil, fmt.Errorf("%w: %v", ErrLoaderPanicked, rec)
+		}
+		if err != nil {
+			atomic.AddInt64(&table.stats.loaderErrors, 1)
+		}
+	}()
+
+	item = loadData(key, args...)
+	if item == nil {
 		return nil, ErrKeyNotFoundOrLoadable
 	}
 
-	return nil, ErrKeyNotFound
+	table.AddCost(key, item.lifeSpan, item.data, item.cost)
+	return item, nil
+}
+
+// loadSingleflight is like load but coalesces concurrent misses for the
+// same key into a single loadData call: the first caller runs the load,
+// later callers block on its result instead of running their own.
+// 【合并并发的loadData调用， 第一个调用者负责加载， 其余调用者等待其结果】
+func (table *CacheTable) loadSingleflight(key interface{}, loadData func(interface{}, ...interface{}) *CacheItem, args ...interface{}) (*CacheItem, error) {
+	table.loaderMutex.Lock()
+	if table.loaderCalls == nil {
+		table.loaderCalls = make(map[interface{}]*loadCall)
+	}
+	if call, ok := table.loaderCalls[key]; ok {
+		table.loaderMutex.Unlock()
+		call.wg.Wait()
+		return call.item, call.err
+	}
+
+	call := new(loadCall)
+	call.wg.Add(1)
+	table.loaderCalls[key] = call
+	table.loaderMutex.Unlock()
+
+	call.item, call.err = table.load(key, loadData, args...)
+
+	table.loaderMutex.Lock()
+	delete(table.loaderCalls, key)
+	table.loaderMutex.Unlock()
+	call.wg.Done()
+
+	return call.item, call.err
 }
 
 // Flush deletes all items from this cache table.
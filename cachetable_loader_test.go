@@ -0,0 +1,52 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLoaderSingleflight spawns many concurrent Value misses for the same
+// key against a slow loader and asserts the loader ran exactly once, with
+// every caller observing its result.
+func TestLoaderSingleflight(t *testing.T) {
+	table := newCacheTable("loader-singleflight")
+	table.SetLoaderSingleflight(true)
+
+	var calls int64
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return NewCacheItem(key, 0, "value")
+	})
+
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			item, err := table.Value("key")
+			if err != nil {
+				t.Errorf("Value returned error: %v", err)
+				return
+			}
+			if item.Data() != "value" {
+				t.Errorf("Value returned %v, want %q", item.Data(), "value")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("loader ran %d times, want exactly 1", got)
+	}
+}
@@ -0,0 +1,26 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import "errors"
+
+// ErrKeyNotFound gets returned when a cache key can not be found.
+// 【key不存在】
+var ErrKeyNotFound = errors.New("key not found in cache")
+
+// ErrKeyNotFoundOrLoadable gets returned when a cache key can not be
+// found and could not be loaded either via the table's data-loader.
+// 【key不存在， loadData也没能加载出来】
+var ErrKeyNotFoundOrLoadable = errors.New("key not found and could not be loaded into cache")
+
+// ErrLoaderPanicked gets returned when a loadData callback panics while
+// being run by Value. The returned error wraps ErrLoaderPanicked, so
+// callers can match it with errors.Is; the recovered panic value is only
+// included in the error's message text.
+// 【loadData回调发生panic时返回的错误】
+var ErrLoaderPanicked = errors.New("loader panicked")
@@ -0,0 +1,415 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+)
+
+// EvictionStats holds the counters an EvictionPolicy exposes about its
+// own behaviour.
+// 【淘汰策略的统计信息】
+type EvictionStats struct {
+	// Admissions counts items that were let in when the table was at
+	// capacity and a victim had to make room.
+	Admissions int64
+	// Rejections counts incoming items that were turned away in favor of
+	// keeping the current victim.
+	Rejections int64
+	// Evictions counts items removed to make room for another item.
+	Evictions int64
+}
+
+// EvictionPolicy decides which item to remove from a capacity-bounded
+// CacheTable and, for admission-aware policies, whether an incoming item
+// is even worth admitting in the first place.
+// 【淘汰策略接口， 由CacheTable在容量已满时调用】
+type EvictionPolicy interface {
+	// Touch is called every time key is added or kept alive, so the
+	// policy can track recency/frequency.
+	Touch(key interface{})
+	// Remove is called whenever key leaves the table, be it via
+	// deletion, expiration or eviction, so the policy can forget it.
+	Remove(key interface{})
+	// Victim returns the key the policy would currently evict to make
+	// room for one more item. ok is false if the policy has nothing to
+	// evict yet (e.g. it hasn't seen any key).
+	Victim() (key interface{}, ok bool)
+	// Admit decides whether candidate should be admitted in place of
+	// victim. Policies that always admit (e.g. plain LRU/LFU) can just
+	// return true.
+	Admit(candidate, victim interface{}) bool
+	// Seen is called for a candidate that Admit rejected. An admitted
+	// candidate is not passed to Seen; it reaches Touch instead. This lets
+	// frequency-based policies (e.g. TinyLFU) still learn from rejected
+	// candidates. Policies that don't track frequency independently of
+	// Touch can make this a no-op.
+	Seen(candidate interface{})
+	// Stats returns the policy's admission/rejection/eviction counters.
+	Stats() EvictionStats
+}
+
+// --- LRU -------------------------------------------------------------
+
+// LRUPolicy evicts the least recently touched key. It always admits the
+// incoming item, i.e. it never rejects in favor of the current victim.
+// 【LRU淘汰策略， 总是接纳新条目】
+type LRUPolicy struct {
+	ll    *list.List
+	elems map[interface{}]*list.Element
+	stats EvictionStats
+}
+
+// NewLRUPolicy returns a ready to use LRUPolicy.
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{
+		ll:    list.New(),
+		elems: make(map[interface{}]*list.Element),
+	}
+}
+
+// Touch implements EvictionPolicy.
+func (p *LRUPolicy) Touch(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+// Remove implements EvictionPolicy.
+func (p *LRUPolicy) Remove(key interface{}) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+// Victim implements EvictionPolicy.
+func (p *LRUPolicy) Victim() (interface{}, bool) {
+	e := p.ll.Back()
+	if e == nil {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// Admit implements EvictionPolicy. LRU always makes room for the newcomer.
+func (p *LRUPolicy) Admit(candidate, victim interface{}) bool {
+	p.stats.Admissions++
+	p.stats.Evictions++
+	return true
+}
+
+// Seen implements EvictionPolicy. LRU has no separate frequency
+// tracking to feed, so this is a no-op.
+func (p *LRUPolicy) Seen(candidate interface{}) {}
+
+// Stats implements EvictionPolicy.
+func (p *LRUPolicy) Stats() EvictionStats {
+	return p.stats
+}
+
+// --- LFU -------------------------------------------------------------
+
+// LFUPolicy evicts the least frequently touched key. Like LRUPolicy it
+// always admits the incoming item.
+// 【LFU淘汰策略， 总是接纳新条目】
+type LFUPolicy struct {
+	freq  map[interface{}]int64
+	stats EvictionStats
+}
+
+// NewLFUPolicy returns a ready to use LFUPolicy.
+func NewLFUPolicy() *LFUPolicy {
+	return &LFUPolicy{freq: make(map[interface{}]int64)}
+}
+
+// Touch implements EvictionPolicy.
+func (p *LFUPolicy) Touch(key interface{}) {
+	p.freq[key]++
+}
+
+// Remove implements EvictionPolicy.
+func (p *LFUPolicy) Remove(key interface{}) {
+	delete(p.freq, key)
+}
+
+// Victim implements EvictionPolicy.
+func (p *LFUPolicy) Victim() (interface{}, bool) {
+	var victim interface{}
+	var min int64
+	found := false
+	for k, c := range p.freq {
+		if !found || c < min {
+			victim, min, found = k, c, true
+		}
+	}
+	return victim, found
+}
+
+// Admit implements EvictionPolicy. LFU always makes room for the newcomer.
+func (p *LFUPolicy) Admit(candidate, victim interface{}) bool {
+	p.stats.Admissions++
+	p.stats.Evictions++
+	return true
+}
+
+// Seen implements EvictionPolicy. LFU only counts frequency via Touch,
+// which already runs for every item actually admitted, so this is a
+// no-op.
+func (p *LFUPolicy) Seen(candidate interface{}) {}
+
+// Stats implements EvictionPolicy.
+func (p *LFUPolicy) Stats() EvictionStats {
+	return p.stats
+}
+
+// --- TinyLFU -----------------------------------------------------------
+
+// countMinSketch is a 4-row count-min sketch with 4-bit saturating
+// counters, used by TinyLFUPolicy to cheaply estimate item frequency.
+// 【count-min sketch， 4行4bit饱和计数器， 用于估算访问频率】
+type countMinSketch struct {
+	rows    [cmDepth][]uint8 // each counter packed two-per-byte
+	width   uint64
+	seeds   [cmDepth]uint64
+	count   int64 // sum of increments since the last reset
+	resetAt int64 // halve the counters once count reaches this
+}
+
+const cmDepth = 4
+
+func newCountMinSketch(width uint64, resetAt int64) *countMinSketch {
+	if width == 0 {
+		width = 1
+	}
+	s := &countMinSketch{width: width, resetAt: resetAt}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, (width+1)/2)
+		s.seeds[i] = maphash.Bytes(maphash.MakeSeed(), []byte{byte(i), byte(i >> 8)})
+	}
+	return s
+}
+
+func (s *countMinSketch) hash(row int, key uint64) uint64 {
+	h := s.seeds[row] ^ key
+	// fmix64-style avalanche so nearby keys land in different slots.
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h % s.width
+}
+
+func (s *countMinSketch) get(row int, idx uint64) uint8 {
+	b := s.rows[row][idx/2]
+	if idx%2 == 0 {
+		return b & 0x0f
+	}
+	return b >> 4
+}
+
+func (s *countMinSketch) set(row int, idx uint64, v uint8) {
+	if v > 0x0f {
+		v = 0x0f
+	}
+	b := &s.rows[row][idx/2]
+	if idx%2 == 0 {
+		*b = (*b &^ 0x0f) | v
+	} else {
+		*b = (*b &^ 0xf0) | (v << 4)
+	}
+}
+
+// Add increments the estimated frequency of key, halving every counter
+// once enough increments have accumulated so frequencies stay relative
+// to recent traffic instead of growing unbounded.
+func (s *countMinSketch) Add(key uint64) {
+	for row := 0; row < cmDepth; row++ {
+		idx := s.hash(row, key)
+		if v := s.get(row, idx); v < 0x0f {
+			s.set(row, idx, v+1)
+		}
+	}
+	s.count++
+	if s.count >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) reset() {
+	for row := range s.rows {
+		for i, b := range s.rows[row] {
+			lo, hi := b&0x0f, (b&0xf0)>>4
+			s.rows[row][i] = (hi>>1)<<4 | (lo >> 1)
+		}
+	}
+	s.count /= 2
+}
+
+// Estimate returns the minimum counter across all rows, the standard
+// count-min point estimate of key's frequency.
+func (s *countMinSketch) Estimate(key uint64) uint8 {
+	min := uint8(0x0f)
+	for row := 0; row < cmDepth; row++ {
+		if v := s.get(row, s.hash(row, key)); v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// doorkeeper is a small bloom filter that must see a key at least twice
+// before the count-min sketch bothers tracking it, keeping one-hit
+// wonders from polluting the sketch.
+// 【doorkeeper布隆过滤器， 过滤掉只出现一次的key】
+type doorkeeper struct {
+	bits  []uint64
+	seeds [cmDepth]uint64
+}
+
+func newDoorkeeper(size uint64) *doorkeeper {
+	if size == 0 {
+		size = 1
+	}
+	d := &doorkeeper{bits: make([]uint64, (size+63)/64)}
+	for i := range d.seeds {
+		d.seeds[i] = maphash.Bytes(maphash.MakeSeed(), []byte{byte(i + 1)})
+	}
+	return d
+}
+
+func (d *doorkeeper) index(row int, key uint64) uint64 {
+	h := d.seeds[row] ^ key
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h % uint64(len(d.bits)*64)
+}
+
+// Allow reports whether key was already known to the doorkeeper, marking
+// it as known either way.
+func (d *doorkeeper) Allow(key uint64) bool {
+	seenBefore := true
+	for row := 0; row < cmDepth; row++ {
+		idx := d.index(row, key)
+		word, bit := idx/64, idx%64
+		if d.bits[word]&(1<<bit) == 0 {
+			seenBefore = false
+			d.bits[word] |= 1 << bit
+		}
+	}
+	return seenBefore
+}
+
+func (d *doorkeeper) Reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+// hashKey turns an arbitrary cache key into a uint64 for the sketch and
+// doorkeeper. Strings and byte slices are hashed directly; everything
+// else falls back to hashing its fmt representation.
+func hashKey(key interface{}) uint64 {
+	var h maphash.Hash
+	switch k := key.(type) {
+	case string:
+		h.WriteString(k)
+	case []byte:
+		h.Write(k)
+	case fmt.Stringer:
+		h.WriteString(k.String())
+	default:
+		h.WriteString(fmt.Sprint(key))
+	}
+	return h.Sum64()
+}
+
+// TinyLFUPolicy is an admission-aware LFU approximation: it keeps an LRU
+// ordering for victim selection, but before evicting the LRU victim to
+// admit a new key it consults a count-min sketch (guarded by a
+// doorkeeper bloom filter) and only admits the newcomer if it is
+// estimated to be accessed more often than the victim.
+// 【TinyLFU淘汰策略， 用count-min sketch估算频率， 只有新key频率更高才淘汰旧key】
+type TinyLFUPolicy struct {
+	window *LRUPolicy
+	sketch *countMinSketch
+	door   *doorkeeper
+	stats  EvictionStats
+}
+
+// NewTinyLFUPolicy returns a TinyLFUPolicy sized for the given capacity.
+// The sketch is sized to roughly 10x capacity counters per row, and
+// resets (halves all counters) once 10x capacity increments have been
+// recorded, per the TinyLFU paper's recommendation.
+func NewTinyLFUPolicy(capacity int) *TinyLFUPolicy {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	width := uint64(capacity * 10)
+	return &TinyLFUPolicy{
+		window: NewLRUPolicy(),
+		sketch: newCountMinSketch(width, int64(capacity)*10),
+		door:   newDoorkeeper(width),
+	}
+}
+
+// Touch implements EvictionPolicy.
+func (p *TinyLFUPolicy) Touch(key interface{}) {
+	p.window.Touch(key)
+	p.Seen(key)
+}
+
+// Remove implements EvictionPolicy.
+func (p *TinyLFUPolicy) Remove(key interface{}) {
+	p.window.Remove(key)
+}
+
+// Victim implements EvictionPolicy.
+func (p *TinyLFUPolicy) Victim() (interface{}, bool) {
+	return p.window.Victim()
+}
+
+// Seen implements EvictionPolicy by recording key in the count-min
+// sketch (behind the doorkeeper) without touching the LRU window. This
+// runs for every admission attempt, including rejected ones, so a
+// genuinely hot newcomer that keeps losing to the current victim still
+// accumulates estimated frequency and can eventually win admission.
+// 【记录到count-min sketch但不影响LRU窗口； 对每次尝试都记录， 即使被拒绝，
+// 这样真正热的新key也能积累频率最终被接纳】
+func (p *TinyLFUPolicy) Seen(key interface{}) {
+	k := hashKey(key)
+	if p.door.Allow(k) {
+		p.sketch.Add(k)
+	}
+}
+
+// Admit implements EvictionPolicy. The incoming candidate is admitted
+// only if its estimated frequency exceeds the victim's; otherwise the
+// candidate is rejected and the victim is left in place.
+func (p *TinyLFUPolicy) Admit(candidate, victim interface{}) bool {
+	candidateFreq := p.sketch.Estimate(hashKey(candidate))
+	victimFreq := p.sketch.Estimate(hashKey(victim))
+	if candidateFreq <= victimFreq {
+		p.stats.Rejections++
+		return false
+	}
+	p.stats.Admissions++
+	p.stats.Evictions++
+	return true
+}
+
+// Stats implements EvictionPolicy.
+func (p *TinyLFUPolicy) Stats() EvictionStats {
+	return p.stats
+}
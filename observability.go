@@ -0,0 +1,215 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of a CacheTable's operation
+// counters, as returned by CacheTable.Stats.
+// 【缓存表操作计数器的快照】
+type Stats struct {
+	Hits           int64
+	Misses         int64
+	LoaderCalls    int64
+	LoaderErrors   int64
+	Adds           int64
+	Deletes        int64
+	Expirations    int64
+	Evictions      int64
+	CurrentSize    int64
+	BytesEstimated int64
+}
+
+// tableStats holds the live, atomically updated counters backing Stats.
+// 【Stats背后实际的原子计数器】
+type tableStats struct {
+	hits, misses                 int64
+	loaderCalls, loaderErrors    int64
+	adds, deletes                int64
+	expirations, evictions       int64
+	currentSize, bytesEstimated  int64
+}
+
+func (s *tableStats) snapshot() Stats {
+	return Stats{
+		Hits:           atomic.LoadInt64(&s.hits),
+		Misses:         atomic.LoadInt64(&s.misses),
+		LoaderCalls:    atomic.LoadInt64(&s.loaderCalls),
+		LoaderErrors:   atomic.LoadInt64(&s.loaderErrors),
+		Adds:           atomic.LoadInt64(&s.adds),
+		Deletes:        atomic.LoadInt64(&s.deletes),
+		Expirations:    atomic.LoadInt64(&s.expirations),
+		Evictions:      atomic.LoadInt64(&s.evictions),
+		CurrentSize:    atomic.LoadInt64(&s.currentSize),
+		BytesEstimated: atomic.LoadInt64(&s.bytesEstimated),
+	}
+}
+
+// Stats returns a snapshot of this table's operation counters. It never
+// blocks on the table's own mutex.
+// 【返回缓存表操作计数器的快照， 不会阻塞在table自身的锁上】
+func (table *CacheTable) Stats() Stats {
+	return table.stats.snapshot()
+}
+
+// DroppedEvents returns how many events published via an operation on
+// this table were dropped because a Subscribe channel was full.
+// 【因订阅者channel已满而丢弃的事件总数】
+func (table *CacheTable) DroppedEvents() int64 {
+	return atomic.LoadInt64(&table.eventsDropped)
+}
+
+// EventType identifies what happened to a CacheItem in an Event.
+// 【事件类型】
+type EventType int
+
+const (
+	// EventAdded fires when an item is added (or replaced) via Add or
+	// NotFoundAdd.
+	EventAdded EventType = iota
+	// EventAccessed fires on every cache hit in Value/ValueContext.
+	EventAccessed
+	// EventExpired fires when expirationCheck passively removes an item
+	// past its lifespan.
+	EventExpired
+	// EventDeleted fires when an item is explicitly removed via Delete.
+	EventDeleted
+	// EventEvicted fires when the eviction policy removes an item to
+	// make room for another.
+	EventEvicted
+)
+
+// Event describes a single change to a CacheTable, delivered to
+// subscribers returned by CacheTable.Subscribe.
+// 【缓存表条目变化事件， 通过Subscribe返回的channel投递】
+type Event struct {
+	Type EventType
+	Key  interface{}
+	Item *CacheItem
+}
+
+// eventBufferSize is the capacity of each subscriber channel. Once full,
+// further events for that subscriber are dropped rather than blocking
+// the operation that produced them.
+const eventBufferSize = 64
+
+// Subscribe returns a channel that receives an Event for every
+// Add/NotFoundAdd, cache hit, passive expiration, explicit Delete, and
+// eviction on this table. Delivery is non-blocking: a subscriber that
+// falls behind has events dropped (see DroppedEvents) rather than
+// stalling the table.
+// 【订阅缓存表事件； 投递是非阻塞的， 订阅者消费不及时会丢弃事件而不是阻塞缓存表操作】
+func (table *CacheTable) Subscribe() <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+
+	table.subMu.Lock()
+	defer table.subMu.Unlock()
+	table.subscribers = append(table.subscribers, ch)
+
+	return ch
+}
+
+// Unsubscribe stops delivering events to a channel returned by
+// Subscribe and closes it.
+// 【取消订阅并关闭channel】
+func (table *CacheTable) Unsubscribe(ch <-chan Event) {
+	table.subMu.Lock()
+	defer table.subMu.Unlock()
+
+	for i, sub := range table.subscribers {
+		var recv <-chan Event = sub
+		if recv == ch {
+			close(sub)
+			table.subscribers = append(table.subscribers[:i], table.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish delivers ev to every subscriber without blocking. subMu is
+// held for the whole send loop, not just the slice read, so Unsubscribe
+// can never close a channel publish is still writing to.
+// 【publish在整个发送循环中持有subMu， 避免Unsubscribe在发送期间关闭channel】
+func (table *CacheTable) publish(ev Event) {
+	table.subMu.Lock()
+	defer table.subMu.Unlock()
+
+	for _, ch := range table.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddInt64(&table.eventsDropped, 1)
+		}
+	}
+}
+
+// PromCounter is the subset of a monotonic metric (e.g.
+// *prometheus.Counter) RegisterPrometheus needs.
+// 【单调计数器需要的最小接口】
+type PromCounter interface {
+	Add(delta float64)
+}
+
+// PromGauge is the subset of a point-in-time metric (e.g.
+// *prometheus.Gauge) RegisterPrometheus needs.
+// 【瞬时值指标需要的最小接口】
+type PromGauge interface {
+	Set(value float64)
+}
+
+// PromRegisterer is the subset of a metrics registry RegisterPrometheus
+// needs to create (and register) the counters/gauges it exports. It
+// lets callers adapt a real prometheus.Registerer without this package
+// importing the prometheus client directly.
+// 【RegisterPrometheus需要的最小注册接口， 由调用方适配真正的prometheus.Registerer】
+type PromRegisterer interface {
+	Counter(name, help string, labelValues ...string) PromCounter
+	Gauge(name, help string, labelValues ...string) PromGauge
+}
+
+// RegisterPrometheus wires this table's Stats into counters/gauges
+// created through reg, polling once a second. labelValues are forwarded
+// to every Counter/Gauge call, letting callers distinguish tables that
+// share one registry.
+// 【把Stats接入reg创建的counter/gauge， 每秒轮询一次】
+func (table *CacheTable) RegisterPrometheus(reg PromRegisterer, labelValues ...string) {
+	hits := reg.Counter("cache2go_hits_total", "Number of cache hits.", labelValues...)
+	misses := reg.Counter("cache2go_misses_total", "Number of cache misses.", labelValues...)
+	loaderCalls := reg.Counter("cache2go_loader_calls_total", "Number of data-loader invocations.", labelValues...)
+	loaderErrors := reg.Counter("cache2go_loader_errors_total", "Number of failed data-loader invocations.", labelValues...)
+	adds := reg.Counter("cache2go_adds_total", "Number of items added.", labelValues...)
+	deletes := reg.Counter("cache2go_deletes_total", "Number of items explicitly deleted.", labelValues...)
+	expirations := reg.Counter("cache2go_expirations_total", "Number of items removed by passive expiration.", labelValues...)
+	evictions := reg.Counter("cache2go_evictions_total", "Number of items removed by the eviction policy.", labelValues...)
+	size := reg.Gauge("cache2go_items", "Current number of items in the table.", labelValues...)
+	bytes := reg.Gauge("cache2go_bytes_estimated", "Current estimated total cost of the table.", labelValues...)
+
+	go func() {
+		var prev Stats
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cur := table.Stats()
+			hits.Add(float64(cur.Hits - prev.Hits))
+			misses.Add(float64(cur.Misses - prev.Misses))
+			loaderCalls.Add(float64(cur.LoaderCalls - prev.LoaderCalls))
+			loaderErrors.Add(float64(cur.LoaderErrors - prev.LoaderErrors))
+			adds.Add(float64(cur.Adds - prev.Adds))
+			deletes.Add(float64(cur.Deletes - prev.Deletes))
+			expirations.Add(float64(cur.Expirations - prev.Expirations))
+			evictions.Add(float64(cur.Evictions - prev.Evictions))
+			size.Set(float64(cur.CurrentSize))
+			bytes.Set(float64(cur.BytesEstimated))
+			prev = cur
+		}
+	}()
+}
@@ -0,0 +1,504 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncMode controls how aggressively a persisted CacheTable flushes its
+// write-ahead log to disk.
+// 【持久化WAL的落盘策略】
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs the WAL after every Add/Delete.
+	SyncAlways SyncMode = iota
+	// SyncInterval only fsyncs on the table's snapshot cadence.
+	SyncInterval
+	// SyncNever leaves fsyncing to the OS; fastest, least durable.
+	SyncNever
+)
+
+// Codec encodes and decodes the interface{} keys and values of a
+// CacheItem for persistence.
+// 【持久化时key/value的编解码器】
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// RegisterPersistentType registers concrete types that will flow through
+// a GobCodec as a CacheItem key or value. Since keys and values are
+// interface{}, gob needs every concrete type registered once, up front,
+// before it is persisted.
+// 【注册gob编解码需要用到的具体类型】
+func RegisterPersistentType(values ...interface{}) {
+	for _, v := range values {
+		gob.Register(v)
+	}
+}
+
+// GobCodec encodes keys/values with encoding/gob. Concrete types must be
+// registered beforehand via RegisterPersistentType.
+// 【基于gob的编解码器】
+type GobCodec struct{}
+
+// Encode implements Codec.
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (GobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSONCodec encodes keys/values with encoding/json. Simpler to inspect
+// on disk than GobCodec, but decodes interface{} values into plain
+// map[string]interface{}/float64/etc rather than the original type.
+// 【基于json的编解码器】
+type JSONCodec struct{}
+
+// Encode implements Codec.
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode implements Codec.
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// PersistenceConfig configures CacheTable.EnablePersistence.
+// 【持久化配置】
+type PersistenceConfig struct {
+	// Dir is where the snapshot and write-ahead log files are kept.
+	Dir string
+	// Codec en/decodes keys and values. Defaults to GobCodec{}.
+	Codec Codec
+	// SnapshotInterval is how often the background goroutine writes a
+	// full snapshot and truncates the WAL. Defaults to 5 minutes.
+	SnapshotInterval time.Duration
+	// Sync controls how aggressively the WAL is flushed to disk.
+	// Defaults to SyncAlways.
+	Sync SyncMode
+}
+
+func (cfg PersistenceConfig) snapshotPath() string { return filepath.Join(cfg.Dir, "snapshot.dat") }
+func (cfg PersistenceConfig) walPath() string      { return filepath.Join(cfg.Dir, "wal.log") }
+
+const (
+	opAdd    byte = 1
+	opDelete byte = 2
+)
+
+// persistedEntry is a single snapshot row, and also the payload of a WAL
+// add record.
+// 【快照中的一行， 也是WAL添加记录的内容】
+type persistedEntry struct {
+	Key       interface{}
+	Data      interface{}
+	LifeSpan  time.Duration
+	Remaining time.Duration
+}
+
+// walValue wraps a bare key or value so it round-trips through GobCodec.
+// gob only encodes/decodes an interface{} value's concrete type when the
+// interface is nested in a struct field declared as interface{} (which is
+// why persistedEntry already works); encoding a bare interface{} directly,
+// as writeRecord used to, always fails to decode with "local interface
+// type *interface {} can only be decoded from remote interface type".
+// 【包装一个裸的interface{}以便能通过GobCodec正确编解码； gob只有在interface{}
+// 嵌套在结构体字段里时才会编码其具体类型（persistedEntry正是这样用的），
+// 直接对裸interface{}编码（writeRecord过去的做法）解码时必定失败】
+type walValue struct {
+	V interface{}
+}
+
+// tablePersistence is the running persistence state attached to a
+// CacheTable once EnablePersistence succeeds.
+type tablePersistence struct {
+	cfg PersistenceConfig
+
+	mu     sync.Mutex
+	wal    *os.File
+	walBuf *bufio.Writer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// EnablePersistence turns on crash-recovery persistence for table. The
+// table's current items are always replaced with whatever replaying the
+// snapshot and/or WAL in cfg.Dir produces (nothing, if cfg.Dir holds
+// neither), before persistence starts recording new changes.
+// 【开启持久化； 若目录下已有快照/WAL则先重放以恢复数据】
+func (table *CacheTable) EnablePersistence(cfg PersistenceConfig) error {
+	if cfg.Dir == "" {
+		return errors.New("cache2go: PersistenceConfig.Dir must be set")
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = GobCodec{}
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = 5 * time.Minute
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return err
+	}
+
+	entries, err := loadPersisted(cfg)
+	if err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(cfg.walPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	p := &tablePersistence{
+		cfg:    cfg,
+		wal:    wal,
+		walBuf: bufio.NewWriter(wal),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	table.Lock()
+	now := time.Now()
+	table.items = make(map[interface{}]*CacheItem, len(entries))
+	for k, e := range entries {
+		if item := restoreItem(e, now); item != nil {
+			table.items[k] = item
+		}
+	}
+	table.persist = p
+	table.Unlock()
+
+	go table.runPersistenceLoop(p)
+	return nil
+}
+
+// restoreItem rebuilds a CacheItem from a persisted entry, recomputing
+// accessedOn relative to now so the item expires Remaining after reload
+// rather than after its original LifeSpan counted from before the
+// restart. Returns nil if the item's effective deadline already passed.
+// 【根据持久化的条目重建CacheItem， 重新计算accessedOn使其在Remaining之后过期； 若已过期则返回nil】
+func restoreItem(e persistedEntry, now time.Time) *CacheItem {
+	if e.LifeSpan > 0 && e.Remaining <= 0 {
+		return nil
+	}
+	item := NewCacheItem(e.Key, e.LifeSpan, e.Data)
+	if e.LifeSpan > 0 {
+		item.accessedOn = now.Add(e.Remaining - e.LifeSpan)
+	}
+	return item
+}
+
+// remainingTTL returns how much longer an item with the given lifeSpan
+// and accessedOn would still live, for embedding in a snapshot record.
+func remainingTTL(lifeSpan time.Duration, accessedOn time.Time) time.Duration {
+	if lifeSpan == 0 {
+		return 0
+	}
+	remaining := lifeSpan - time.Since(accessedOn)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+// loadPersisted reconstructs the entry set for a table by replaying its
+// latest snapshot followed by its WAL. It never touches a live table and
+// fires none of the aboutToDeleteItem/aboutToExpire callbacks, since
+// those only make sense for live evictions, not a cold replay.
+// 【重放最近一次快照和WAL以重建条目集合； 不触碰实时的table， 也不触发回调】
+func loadPersisted(cfg PersistenceConfig) (map[interface{}]persistedEntry, error) {
+	entries := make(map[interface{}]persistedEntry)
+
+	if raw, err := os.ReadFile(cfg.snapshotPath()); err == nil {
+		var snap []persistedEntry
+		if err := cfg.Codec.Decode(raw, &snap); err != nil {
+			return nil, err
+		}
+		for _, e := range snap {
+			entries[e.Key] = e
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(cfg.walPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		rec, err := readRecord(r, cfg.Codec)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// A record cut short mid-write is expected if the
+				// process crashed before finishing it; stop replaying
+				// instead of failing the whole load. Any other error
+				// (CRC mismatch, codec decode failure) is a genuine
+				// corruption or bug and must not be swallowed the same
+				// way, or a broken codec would silently discard the
+				// entire WAL on every restart.
+				// 【记录在写入一半时被截断是进程崩溃时的正常现象， 应停止重放而不是让整次加载失败；
+				// 其它错误（CRC不匹配、编解码失败）是真正的损坏或bug， 不能同样静默吞掉，
+				// 否则编解码器有问题时每次重启都会静默丢弃整个WAL】
+				break
+			}
+			return nil, err
+		}
+		switch rec.op {
+		case opAdd:
+			entries[rec.key] = persistedEntry{Key: rec.key, Data: rec.data, LifeSpan: rec.lifeSpan, Remaining: rec.remaining}
+		case opDelete:
+			delete(entries, rec.key)
+		}
+	}
+	return entries, nil
+}
+
+// walRecord is a decoded WAL frame.
+type walRecord struct {
+	op        byte
+	key       interface{}
+	lifeSpan  time.Duration
+	remaining time.Duration
+	data      interface{}
+}
+
+// writeRecord appends one framed WAL record: op byte, length-prefixed
+// key, lifeSpan, remaining TTL, length-prefixed value, then a CRC32 of
+// everything before it.
+// 【写入一条帧化的WAL记录： op字节、带长度前缀的key、lifeSpan、剩余TTL、带长度前缀的value， 最后是前面内容的CRC32】
+func writeRecord(w io.Writer, op byte, key interface{}, lifeSpan, remaining time.Duration, data interface{}, codec Codec) error {
+	keyBytes, err := codec.Encode(walValue{V: key})
+	if err != nil {
+		return err
+	}
+
+	var dataBytes []byte
+	if op == opAdd {
+		if dataBytes, err = codec.Encode(walValue{V: data}); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(op)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(keyBytes)))
+	buf.Write(keyBytes)
+	binary.Write(&buf, binary.LittleEndian, int64(lifeSpan))
+	binary.Write(&buf, binary.LittleEndian, int64(remaining))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(dataBytes)))
+	buf.Write(dataBytes)
+
+	crc := crc32.ChecksumIEEE(buf.Bytes())
+	binary.Write(&buf, binary.LittleEndian, crc)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// readRecord reads and validates one WAL frame written by writeRecord.
+func readRecord(r *bufio.Reader, codec Codec) (walRecord, error) {
+	var rec walRecord
+
+	op, err := r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+
+	var keyLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+		return rec, err
+	}
+	keyBytes := make([]byte, keyLen)
+	if _, err := io.ReadFull(r, keyBytes); err != nil {
+		return rec, err
+	}
+
+	var lifeSpan, remaining int64
+	if err := binary.Read(r, binary.LittleEndian, &lifeSpan); err != nil {
+		return rec, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &remaining); err != nil {
+		return rec, err
+	}
+
+	var dataLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &dataLen); err != nil {
+		return rec, err
+	}
+	dataBytes := make([]byte, dataLen)
+	if _, err := io.ReadFull(r, dataBytes); err != nil {
+		return rec, err
+	}
+
+	var wantCRC uint32
+	if err := binary.Read(r, binary.LittleEndian, &wantCRC); err != nil {
+		return rec, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(op)
+	binary.Write(&buf, binary.LittleEndian, keyLen)
+	buf.Write(keyBytes)
+	binary.Write(&buf, binary.LittleEndian, lifeSpan)
+	binary.Write(&buf, binary.LittleEndian, remaining)
+	binary.Write(&buf, binary.LittleEndian, dataLen)
+	buf.Write(dataBytes)
+	if crc32.ChecksumIEEE(buf.Bytes()) != wantCRC {
+		return rec, errors.New("cache2go: corrupt WAL record")
+	}
+
+	var key walValue
+	if err := codec.Decode(keyBytes, &key); err != nil {
+		return rec, err
+	}
+	rec.op = op
+	rec.key = key.V
+	rec.lifeSpan = time.Duration(lifeSpan)
+	rec.remaining = time.Duration(remaining)
+
+	if op == opAdd {
+		var data walValue
+		if err := codec.Decode(dataBytes, &data); err != nil {
+			return rec, err
+		}
+		rec.data = data.V
+	}
+	return rec, nil
+}
+
+// appendRecord writes a WAL frame and, depending on the table's sync
+// mode, flushes and fsyncs it immediately.
+// 【写入一条WAL记录， 根据同步模式决定是否立即flush/fsync】
+func (p *tablePersistence) appendRecord(op byte, key interface{}, lifeSpan, remaining time.Duration, data interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := writeRecord(p.walBuf, op, key, lifeSpan, remaining, data, p.cfg.Codec); err != nil {
+		return err
+	}
+
+	if p.cfg.Sync != SyncAlways {
+		// SyncInterval/SyncNever leave the record buffered; the
+		// periodic snapshot flushes (and, for SyncInterval, fsyncs) it.
+		return nil
+	}
+	if err := p.walBuf.Flush(); err != nil {
+		return err
+	}
+	return p.wal.Sync()
+}
+
+// runPersistenceLoop periodically snapshots the table and truncates its
+// WAL until p.stop is closed. Nothing in this package closes p.stop, so
+// the loop runs until the process exits, and no final snapshot or WAL
+// flush happens at exit; there is no explicit stop method, matching the
+// fire-and-forget lifetime of CacheTable's own expiration timer.
+func (table *CacheTable) runPersistenceLoop(p *tablePersistence) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.cfg.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := table.snapshotAndTruncate(p); err != nil {
+				table.log("Persistence snapshot failed for table", table.name, ":", err)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// snapshotAndTruncate writes a full snapshot of the table's current
+// items, then truncates the WAL now that it is fully captured by the
+// snapshot. table.Lock is held for the whole copy-then-truncate window
+// (not just the item copy): addInternal/deleteInternal also require
+// table.Lock before they append to the WAL, so holding it here too rules
+// out an Add/Delete landing in the gap between the item copy and the
+// truncate, which would otherwise be captured by neither the snapshot
+// nor the (about to be truncated) WAL and be lost entirely.
+// 【写入一份完整快照， 然后截断WAL； 整个复制到截断的过程都持有table.Lock，
+// 避免Add/Delete落在复制快照和截断WAL之间的空档而彻底丢失】
+func (table *CacheTable) snapshotAndTruncate(p *tablePersistence) error {
+	table.Lock()
+	defer table.Unlock()
+
+	entries := make([]persistedEntry, 0, len(table.items))
+	for k, item := range table.items {
+		item.RLock()
+		entries = append(entries, persistedEntry{
+			Key:       k,
+			Data:      item.data,
+			LifeSpan:  item.lifeSpan,
+			Remaining: remainingTTL(item.lifeSpan, item.accessedOn),
+		})
+		item.RUnlock()
+	}
+
+	raw, err := p.cfg.Codec.Encode(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.cfg.snapshotPath() + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p.cfg.snapshotPath()); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.walBuf.Flush(); err != nil {
+		return err
+	}
+	if p.cfg.Sync != SyncNever {
+		if err := p.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := p.wal.Truncate(0); err != nil {
+		return err
+	}
+	_, err = p.wal.Seek(0, io.SeekStart)
+	return err
+}
@@ -0,0 +1,257 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"time"
+)
+
+// ShardedCacheTable splits a cache table's items across N independent
+// CacheTables ("shards"), each with its own mutex and expiration timer
+// loop, so that Add/Delete/Value calls against different keys don't
+// contend on a single lock. It mirrors the core CacheTable methods (Add,
+// Delete, Exists, NotFoundAdd, Value, Flush, Count, Foreach,
+// MostAccessed, and the data-loader, callback and logger setters); the
+// other CacheTable methods, such as eviction, persistence, stats and
+// subscription, have no ShardedCacheTable counterpart.
+// 【分片缓存表， 每个分片是一个独立的CacheTable， 减少单一锁的竞争】
+type ShardedCacheTable struct {
+	name   string
+	shards []*CacheTable
+}
+
+// NewShardedCacheTable creates a ShardedCacheTable with n shards. n is
+// clamped to at least 1.
+// 【创建分片缓存表， n表示分片数量， 最小为1】
+func NewShardedCacheTable(name string, n int) *ShardedCacheTable {
+	if n < 1 {
+		n = 1
+	}
+
+	s := &ShardedCacheTable{
+		name:   name,
+		shards: make([]*CacheTable, n),
+	}
+	for i := range s.shards {
+		s.shards[i] = newCacheTable(fmt.Sprintf("%s-shard%d", name, i))
+	}
+	return s
+}
+
+// ShardCount returns the number of shards backing this table.
+// 【返回分片数量】
+func (s *ShardedCacheTable) ShardCount() int {
+	return len(s.shards)
+}
+
+// shardFor routes key to one of the table's shards. Strings and byte
+// slices are hashed directly; any other key type falls back to hashing
+// its fmt representation (itself reflection-based) so arbitrary
+// interface{} keys still route deterministically.
+// 【根据key路由到对应的分片， 字符串/字节切片直接哈希， 其它类型退化为对其fmt表示做哈希】
+func (s *ShardedCacheTable) shardFor(key interface{}) *CacheTable {
+	return s.shards[shardIndex(key, len(s.shards))]
+}
+
+func shardIndex(key interface{}, n int) int {
+	if n <= 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case []byte:
+		h.Write(k)
+	case fmt.Stringer:
+		h.Write([]byte(k.String()))
+	default:
+		h.Write([]byte(fmt.Sprint(key)))
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+// Add adds a key/value pair to the cache, in the shard key routes to.
+// 【添加缓存条目到对应的分片】
+func (s *ShardedCacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	return s.shardFor(key).Add(key, lifeSpan, data)
+}
+
+// Delete removes an item from the cache.
+// 【从对应的分片中删除缓存条目】
+func (s *ShardedCacheTable) Delete(key interface{}) (*CacheItem, error) {
+	return s.shardFor(key).Delete(key)
+}
+
+// Exists returns whether an item exists in the cache.
+// 【是否存在某个key】
+func (s *ShardedCacheTable) Exists(key interface{}) bool {
+	return s.shardFor(key).Exists(key)
+}
+
+// NotFoundAdd tests whether an item is not found in the cache, adding it
+// if not.
+// 【不存在才添加】
+func (s *ShardedCacheTable) NotFoundAdd(key interface{}, lifeSpan time.Duration, data interface{}) bool {
+	return s.shardFor(key).NotFoundAdd(key, lifeSpan, data)
+}
+
+// Value returns an item from the cache and marks it to be kept alive.
+// 【获取value】
+func (s *ShardedCacheTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+	return s.shardFor(key).Value(key, args...)
+}
+
+// Flush deletes all items from every shard.
+// 【清除所有分片的缓存条目】
+func (s *ShardedCacheTable) Flush() {
+	for _, shard := range s.shards {
+		shard.Flush()
+	}
+}
+
+// Count returns how many items are currently stored across all shards.
+// 【返回所有分片的条目总数】
+func (s *ShardedCacheTable) Count() int {
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.Count()
+	}
+	return count
+}
+
+// Foreach iterates over the items of every shard. Unlike CacheTable's
+// Foreach, no single lock is held across the whole call; each shard is
+// locked only while it is being visited.
+// 【遍历所有分片的缓存条目， 每个分片单独加锁遍历】
+func (s *ShardedCacheTable) Foreach(trans func(key interface{}, item *CacheItem)) {
+	for _, shard := range s.shards {
+		shard.Foreach(trans)
+	}
+}
+
+// SetDataLoader configures the data-loader callback on every shard.
+// 【为所有分片设置loadData回调函数】
+func (s *ShardedCacheTable) SetDataLoader(f func(interface{}, ...interface{}) *CacheItem) {
+	for _, shard := range s.shards {
+		shard.SetDataLoader(f)
+	}
+}
+
+// SetAddedItemCallback configures the added-item callback on every shard.
+// 【为所有分片设置添加缓存条目时触发的回调函数】
+func (s *ShardedCacheTable) SetAddedItemCallback(f func(*CacheItem)) {
+	for _, shard := range s.shards {
+		shard.SetAddedItemCallback(f)
+	}
+}
+
+// SetAboutToDeleteItemCallback configures the about-to-delete callback on
+// every shard.
+// 【为所有分片设置删除缓存条目时触发的回调函数】
+func (s *ShardedCacheTable) SetAboutToDeleteItemCallback(f func(*CacheItem)) {
+	for _, shard := range s.shards {
+		shard.SetAboutToDeleteItemCallback(f)
+	}
+}
+
+// SetLogger sets the logger to be used by every shard.
+// 【为所有分片设置日志】
+func (s *ShardedCacheTable) SetLogger(logger *log.Logger) {
+	for _, shard := range s.shards {
+		shard.SetLogger(logger)
+	}
+}
+
+// minPairHeap is a min-heap of CacheItemPair ordered by ascending
+// AccessCount, used to keep the top-N most accessed items per shard
+// without sorting every item in that shard.
+// 【按AccessCount升序排列的最小堆， 用于每个分片内只保留访问次数最多的count个】
+type minPairHeap []CacheItemPair
+
+func (h minPairHeap) Len() int            { return len(h) }
+func (h minPairHeap) Less(i, j int) bool  { return h[i].AccessCount < h[j].AccessCount }
+func (h minPairHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minPairHeap) Push(x interface{}) { *h = append(*h, x.(CacheItemPair)) }
+func (h *minPairHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	v := old[n-1]
+	*h = old[:n-1]
+	return v
+}
+
+// shardMostAccessed returns, for a single shard, the up-to-count most
+// accessed items using a bounded min-heap: once the heap holds count
+// entries, a new candidate is only pushed if it beats the current
+// smallest, which is then popped.
+func shardMostAccessed(shard *CacheTable, count int64) []CacheItemPair {
+	shard.RLock()
+	defer shard.RUnlock()
+
+	h := make(minPairHeap, 0, count)
+	for k, v := range shard.items {
+		pair := CacheItemPair{Key: k, AccessCount: v.accessCount}
+		if int64(len(h)) < count {
+			heap.Push(&h, pair)
+		} else if len(h) > 0 && pair.AccessCount > h[0].AccessCount {
+			heap.Pop(&h)
+			heap.Push(&h, pair)
+		}
+	}
+	return h
+}
+
+// MostAccessed returns the most accessed items across all shards. Each
+// shard contributes its own top-count items via a bounded min-heap, and
+// the per-shard results are merged and re-ranked to produce the overall
+// top-count.
+// 【获取所有分片中访问最多的count个条目， 每个分片用最小堆得到局部top-count再合并】
+func (s *ShardedCacheTable) MostAccessed(count int64) []*CacheItem {
+	if count <= 0 {
+		return nil
+	}
+
+	var merged CacheItemPairList
+	for _, shard := range s.shards {
+		merged = append(merged, shardMostAccessed(shard, count)...)
+	}
+
+	h := minPairHeap(make([]CacheItemPair, 0, count))
+	for _, pair := range merged {
+		if int64(len(h)) < count {
+			heap.Push(&h, pair)
+		} else if len(h) > 0 && pair.AccessCount > h[0].AccessCount {
+			heap.Pop(&h)
+			heap.Push(&h, pair)
+		}
+	}
+
+	// Heap order isn't access-count order; sort the trimmed result
+	// descending, matching CacheTable.MostAccessed's output order.
+	top := CacheItemPairList(h)
+	sort.Sort(top)
+
+	r := make([]*CacheItem, 0, len(top))
+	for _, pair := range top {
+		shard := s.shards[shardIndex(pair.Key, len(s.shards))]
+		shard.RLock()
+		item, ok := shard.items[pair.Key]
+		shard.RUnlock()
+		if ok {
+			r = append(r, item)
+		}
+	}
+	return r
+}
@@ -0,0 +1,46 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import (
+	"strconv"
+	"testing"
+)
+
+// benchmarkShardedValue measures read-heavy throughput against a table
+// with the given shard count, under concurrency proportional to GOMAXPROCS.
+func benchmarkShardedValue(b *testing.B, shards int) {
+	table := NewShardedCacheTable("bench", shards)
+	for i := 0; i < 1000; i++ {
+		table.Add(strconv.Itoa(i), 0, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := strconv.Itoa(i % 1000)
+			if _, err := table.Value(key); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkShardedValue_1Shard and BenchmarkShardedValue_16Shards compare a
+// single-shard table (all callers contend on one CacheTable's lock)
+// against 16 shards, to quantify the throughput gained by
+// NewShardedCacheTable.
+func BenchmarkShardedValue_1Shard(b *testing.B) {
+	benchmarkShardedValue(b, 1)
+}
+
+func BenchmarkShardedValue_16Shards(b *testing.B) {
+	benchmarkShardedValue(b, 16)
+}
@@ -0,0 +1,166 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go
+
+import "time"
+
+// Typed is a generic, type-safe view of a *CacheTable. It stores keys
+// and values through the table's existing interface{} machinery, so a
+// Typed[K, V] and its underlying CacheTable share the same expiration,
+// eviction, and persistence behaviour; only the call-site type
+// assertions go away.
+//
+// Migrating existing code: wrap the table returned by cache2go.Cache(name)
+// with NewTyped instead of using it directly, e.g.
+// users := cache2go.NewTyped[string, *User](cache2go.Cache("users")).
+// Everything configured on the table before wrapping (loaders,
+// callbacks, eviction policy, persistence) keeps working; callers that
+// still hold the *CacheTable can keep using it untyped alongside the
+// Typed view.
+// 【泛型类型安全包装， 内部仍复用CacheTable的interface{}存储与过期/淘汰/持久化机制】
+type Typed[K comparable, V any] struct {
+	table *CacheTable
+}
+
+// NewTyped wraps table as a Typed[K, V]. Keys and values added through
+// the returned Typed are expected to always be of type K and V; the
+// underlying table itself is untouched and can still be used directly.
+// 【包装一个已有的CacheTable】
+func NewTyped[K comparable, V any](table *CacheTable) *Typed[K, V] {
+	return &Typed[K, V]{table: table}
+}
+
+// Table returns the underlying *CacheTable backing this typed view.
+func (t *Typed[K, V]) Table() *CacheTable {
+	return t.table
+}
+
+// TypedItem wraps a *CacheItem so Data and Key return V and K directly,
+// without a type assertion at the call site.
+// 【泛型条目包装】
+type TypedItem[K comparable, V any] struct {
+	item *CacheItem
+}
+
+// Data returns the value of this cached item.
+func (t *TypedItem[K, V]) Data() V {
+	return t.item.Data().(V)
+}
+
+// Key returns the key of this cached item.
+func (t *TypedItem[K, V]) Key() K {
+	return t.item.Key().(K)
+}
+
+// LifeSpan returns this item's expiration duration.
+func (t *TypedItem[K, V]) LifeSpan() time.Duration {
+	return t.item.LifeSpan()
+}
+
+// AccessedOn returns when this item was last accessed.
+func (t *TypedItem[K, V]) AccessedOn() time.Time {
+	return t.item.AccessedOn()
+}
+
+// CreatedOn returns when this item was added to the cache.
+func (t *TypedItem[K, V]) CreatedOn() time.Time {
+	return t.item.CreatedOn()
+}
+
+// AccessCount returns how often this item has been accessed.
+func (t *TypedItem[K, V]) AccessCount() int64 {
+	return t.item.AccessCount()
+}
+
+// KeepAlive marks the item to be kept for another lifeSpan period.
+func (t *TypedItem[K, V]) KeepAlive() {
+	t.item.KeepAlive()
+}
+
+// Add adds a key/value pair to the cache.
+func (t *Typed[K, V]) Add(key K, lifeSpan time.Duration, data V) *TypedItem[K, V] {
+	return &TypedItem[K, V]{item: t.table.Add(key, lifeSpan, data)}
+}
+
+// Value returns an item from the cache and marks it to be kept alive.
+// You can pass additional arguments to your DataLoader callback
+// function.
+func (t *Typed[K, V]) Value(key K, args ...interface{}) (*TypedItem[K, V], error) {
+	item, err := t.table.Value(key, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedItem[K, V]{item: item}, nil
+}
+
+// Delete removes an item from the cache.
+func (t *Typed[K, V]) Delete(key K) (*TypedItem[K, V], error) {
+	item, err := t.table.Delete(key)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedItem[K, V]{item: item}, nil
+}
+
+// Exists returns whether an item exists in the cache.
+func (t *Typed[K, V]) Exists(key K) bool {
+	return t.table.Exists(key)
+}
+
+// NotFoundAdd tests whether an item is not found in the cache, adding it
+// if not.
+func (t *Typed[K, V]) NotFoundAdd(key K, lifeSpan time.Duration, data V) bool {
+	return t.table.NotFoundAdd(key, lifeSpan, data)
+}
+
+// SetDataLoader configures a data-loader callback, which will be called
+// when trying to access a non-existing key. Returning a non-nil error
+// leaves the key unloadable, mirroring CacheTable's loadData returning
+// nil.
+func (t *Typed[K, V]) SetDataLoader(f func(key K, args ...interface{}) (V, time.Duration, error)) {
+	t.table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		data, lifeSpan, err := f(key.(K), args...)
+		if err != nil {
+			return nil
+		}
+		return NewCacheItem(key, lifeSpan, data)
+	})
+}
+
+// SetAddedItemCallback configures a callback, which will be called every
+// time a new item is added to the cache.
+func (t *Typed[K, V]) SetAddedItemCallback(f func(*TypedItem[K, V])) {
+	t.table.SetAddedItemCallback(func(item *CacheItem) {
+		f(&TypedItem[K, V]{item: item})
+	})
+}
+
+// SetAboutToDeleteItemCallback configures a callback, which will be
+// called every time an item is about to be removed from the cache.
+func (t *Typed[K, V]) SetAboutToDeleteItemCallback(f func(*TypedItem[K, V])) {
+	t.table.SetAboutToDeleteItemCallback(func(item *CacheItem) {
+		f(&TypedItem[K, V]{item: item})
+	})
+}
+
+// Foreach iterates over all items in the cache.
+func (t *Typed[K, V]) Foreach(trans func(K, V)) {
+	t.table.Foreach(func(key interface{}, item *CacheItem) {
+		trans(key.(K), item.Data().(V))
+	})
+}
+
+// Count returns how many items are currently stored in the cache.
+func (t *Typed[K, V]) Count() int {
+	return t.table.Count()
+}
+
+// Flush deletes all items from this cache table.
+func (t *Typed[K, V]) Flush() {
+	t.table.Flush()
+}
@@ -0,0 +1,42 @@
+/*
+ * Simple caching library with expiration capabilities
+ *     Copyright (c) 2013-2017, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cache2go_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/muesli/cache2go"
+)
+
+// User is a stand-in for whatever domain type an application keys its
+// cache by.
+type User struct {
+	Name string
+}
+
+// This example shows the type-safe loader pattern: wrap a table obtained
+// from cache2go.Cache with NewTyped, then configure a loader that returns
+// V directly instead of interface{}, removing the type assertion from
+// every call site.
+func Example_typedLoader() {
+	users := cache2go.NewTyped[string, *User](cache2go.Cache("users"))
+	users.SetDataLoader(func(key string, args ...interface{}) (*User, time.Duration, error) {
+		return &User{Name: key}, 0, nil
+	})
+
+	item, err := users.Value("alice")
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	fmt.Println(item.Data().Name)
+
+	// Output:
+	// alice
+}